@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	ModeTransient  = "transient"
+	ModePersistent = "persistent"
+)
+
+// connPoolRedialBackoff is how long redialUntilSuccess waits between retries after a failed background redial.
+const connPoolRedialBackoff = time.Second
+
+// pooledConn wraps a net.Conn together with the number of messages written to it since it was dialed, so the pool
+// can enforce -messages-per-conn churn.
+type pooledConn struct {
+	net.Conn
+	writes int
+}
+
+// connPool maintains a fixed number of live connections, acquired/released around each message send instead of
+// dialing and closing a connection per message. It reopens connections that were found broken
+// (EPIPE/ECONNRESET) or that reached -messages-per-conn writes.
+type connPool struct {
+	conns           chan *pooledConn
+	dial            dialFunc
+	messagesPerConn int
+}
+
+// newConnPool dials size connections via dial up front and returns a pool ready to serve acquire/release.
+func newConnPool(ctx context.Context, dial dialFunc, size, messagesPerConn int) (*connPool, error) {
+	p := &connPool{
+		conns:           make(chan *pooledConn, size),
+		dial:            dial,
+		messagesPerConn: messagesPerConn,
+	}
+	for i := 0; i < size; i++ {
+		pc, err := p.dialOne(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not pre-dial persistent connection %d/%d: %w", i+1, size, err)
+		}
+		p.conns <- pc
+	}
+	return p, nil
+}
+
+// dialOne opens a new connection to the pool's target.
+func (p *connPool) dialOne(ctx context.Context) (*pooledConn, error) {
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledConn{Conn: conn}, nil
+}
+
+// acquire removes a connection from the pool, blocking until one is available.
+func (p *connPool) acquire(ctx context.Context) (*pooledConn, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case pc := <-p.conns:
+		return pc, nil
+	}
+}
+
+// release returns pc to the pool. If sendErr indicates a broken connection, or the connection has reached
+// -messages-per-conn writes, it is closed and replaced with a freshly dialed one before being returned to the pool.
+func (p *connPool) release(ctx context.Context, pc *pooledConn, sendErr error) {
+	if isBrokenConnErr(sendErr) || (p.messagesPerConn > 0 && pc.writes >= p.messagesPerConn) {
+		pc.Close()
+		fresh, err := p.dialOne(ctx)
+		if err != nil {
+			// Don't put pc back: it's already closed, so every write to it would fail forever with
+			// net.ErrClosed, permanently poisoning this slot. Retry the dial in the background instead, so a
+			// transient dial outage only shrinks the pool temporarily.
+			go p.redialUntilSuccess(ctx)
+			return
+		}
+		p.conns <- fresh
+		return
+	}
+	p.conns <- pc
+}
+
+// redialUntilSuccess retries dialing a replacement connection, backing off connPoolRedialBackoff between
+// attempts, until it succeeds or ctx is done, then returns the new connection to the pool.
+func (p *connPool) redialUntilSuccess(ctx context.Context) {
+	for {
+		if pc, err := p.dialOne(ctx); err == nil {
+			p.conns <- pc
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(connPoolRedialBackoff):
+		}
+	}
+}
+
+// isBrokenConnErr reports whether err indicates that a TCP connection was broken out from under us (EPIPE/ECONNRESET).
+func isBrokenConnErr(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// sendPersistent returns a sendFunc that writes message to a connection acquired from pool, releasing it (and
+// transparently redialing on breakage or churn) afterwards.
+func sendPersistent(pool *connPool) sendFunc {
+	return func(ctx context.Context, message string) error {
+		pc, err := pool.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		_, writeErr := fmt.Fprint(pc.Conn, message)
+		pc.writes++
+		pool.release(ctx, pc, writeErr)
+		return writeErr
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaInterval is the tick period the decay factor of ewma is calibrated against, matching the classic Unix load
+// average averaging window convention.
+const ewmaInterval = 5 * time.Second
+
+// ewma is an exponentially weighted moving average of a counter, following the same uncounted-then-tick shape as
+// the standard Unix load average: callers call update() as events happen, and a ticker calls tick() once per
+// ewmaInterval to fold the uncounted events into the decaying rate.
+type ewma struct {
+	mu        sync.Mutex
+	uncounted int64
+	rate      float64
+	ticked    bool
+}
+
+// newEWMA returns a zeroed ewma ready to accumulate updates.
+func newEWMA() *ewma {
+	return &ewma{}
+}
+
+// update records n events (or bytes) since the last tick.
+func (e *ewma) update(n int64) {
+	atomic.AddInt64(&e.uncounted, n)
+}
+
+// tick folds the events accumulated since the previous tick into the decaying average, using the standard
+// 1-e^-1 decay per tick.
+func (e *ewma) tick() {
+	count := atomic.SwapInt64(&e.uncounted, 0)
+	instantRate := float64(count) / ewmaInterval.Seconds()
+	const alpha = 1 - 0.36787944117144233 // 1 - e^-1
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ticked {
+		e.rate = instantRate
+		e.ticked = true
+		return
+	}
+	e.rate += alpha * (instantRate - e.rate)
+}
+
+// value returns the current moving-average rate, in events per second.
+func (e *ewma) value() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rate
+}
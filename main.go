@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,85 +18,156 @@ const (
 	NANOSECONDS_PER_SECOND = 1000000000
 	TCP                    = "tcp"
 	UDP                    = "udp"
+	Unix                   = "unix"
+	Unixgram               = "unixgram"
 )
 
-// client implements the client logic for a single connection. It opens a connection of type proto (TCP or UDP) to
-// <host>:<port> and it writes <message> to the connection before closing it.
+// client implements the client logic for a single connection. It opens a connection via dial and it writes
+// <message> to the connection before closing it. ctx allows the dial to be aborted when the worker pool is
+// shutting down.
 // Note: The terminology may be a bit confusing as the client pushes data to the server, not the other way around.
-func client(proto, host string, port int, message string) error {
-	conn, err := net.Dial(proto, fmt.Sprintf("%s:%d", host, port))
+func client(ctx context.Context, dial dialFunc, message string) error {
+	conn, err := dial(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	fmt.Fprint(conn, message)
+	if _, err := fmt.Fprint(conn, message); err != nil {
+		return err
+	}
 	return nil
 }
 
-// server implements the logic for the server. It uses helper functions tcpServer and udpServer to implement servers
-// for the respective protocols.
-func server(proto, host string, port int) error {
-	hostPort := fmt.Sprintf("%s:%d", host, port)
-	if proto == UDP {
-		return udpServer(proto, hostPort)
+// sendTransient returns a sendFunc that dials a fresh connection via dial for every message, as client() always did
+// before connection pooling was introduced.
+func sendTransient(dial dialFunc) sendFunc {
+	return func(ctx context.Context, message string) error {
+		return client(ctx, dial, message)
 	}
-	if proto == TCP {
-		return tcpServer(proto, hostPort)
+}
+
+// plainDialer returns a dialFunc that opens a plain (non-TLS) connection to addr via transport.
+func plainDialer(transport Transport, dialer *net.Dialer, addr string) dialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		return transport.Dial(ctx, dialer, addr)
 	}
-	return fmt.Errorf("unsupported protocol: %q", proto)
 }
 
-// udpServer implements the logic for a UDP server. It listens on a given UDP socket. It reads from the socket and
-// prints the message of the client if flag -debug was provided.
-func udpServer(proto, hostPort string) error {
-	addr, err := net.ResolveUDPAddr(proto, hostPort)
-	if err != nil {
+// tlsDialer returns a dialFunc that opens a TLS connection to addr via transport, using cfg.
+func tlsDialer(transport Transport, dialer *net.Dialer, cfg *tls.Config, addr string) dialFunc {
+	td := &tls.Dialer{NetDialer: dialer, Config: cfg}
+	return func(ctx context.Context) (net.Conn, error) {
+		return td.DialContext(ctx, transport.Name(), addr)
+	}
+}
+
+// instrumented wraps send so that every call's wall time (dial + write) is recorded into m's latency histogram,
+// along with whether it errored.
+func instrumented(send sendFunc, m *metrics) sendFunc {
+	return func(ctx context.Context, message string) error {
+		start := time.Now()
+		err := send(ctx, message)
+		m.recordRequest(time.Since(start), err)
 		return err
 	}
-	conn, err := net.ListenUDP("udp", addr)
+}
+
+// server implements the logic for the server. It dispatches to streamServer or packetServer depending on whether
+// transport is connection-oriented or connectionless. tlsConfig is non-nil when -tls was set; it is only supported
+// over connection-oriented transports. It runs until ctx is cancelled, then shuts down gracefully, giving in-flight
+// connections up to shutdownTimeout to finish.
+func server(ctx context.Context, transport Transport, addr string, m *metrics, tlsConfig *tls.Config, shutdownTimeout time.Duration) error {
+	if transport.IsPacket() {
+		if tlsConfig != nil {
+			return fmt.Errorf("-tls is not supported with -protocol=%s", transport.Name())
+		}
+		return packetServer(ctx, transport, addr, m)
+	}
+	return streamServer(ctx, transport, addr, m, tlsConfig, shutdownTimeout)
+}
+
+// packetServer implements the logic for a connectionless server (UDP, Unix datagram sockets). It listens on a
+// given socket, reads from it, and prints the message of the client if flag -debug was provided. It returns once
+// ctx is cancelled and the socket is closed to unblock the pending read.
+func packetServer(ctx context.Context, transport Transport, addr string, m *metrics) error {
+	conn, err := transport.ListenPacket(addr)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
 	buffer := make([]byte, 1024)
 	for {
-		n, remote, err := conn.ReadFromUDP(buffer)
+		n, remote, err := conn.ReadFrom(buffer)
 		if err != nil {
-			log.Printf("could not read from UDP buffer, err: %q", err)
-			continue
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				log.Printf("could not read from %s buffer, err: %q", transport.Name(), err)
+				continue
+			}
 		}
+		m.recordMessage(n)
 		if *debugFlag {
 			log.Printf("read from remote %s: %s", remote, string(buffer[:n]))
 		}
 	}
 }
 
-// tcpServer implements the logic for a TCP server. It listens on a given TCP socket. It accepts connections and then
-// handles them in another go routine, handleConnection(conn).
-func tcpServer(proto, hostPort string) error {
-	ln, err := net.Listen(proto, hostPort)
+// streamServer implements the logic for a connection-oriented server (TCP, Unix stream sockets). It listens on a
+// given socket, wrapping the listener with TLS (and, if the config requires client certs, mTLS) when tlsConfig is
+// non-nil. It accepts connections and then handles them in another go routine, handleConnection(conn). Once ctx is
+// cancelled, it closes the listener and waits, bounded by shutdownTimeout, for in-flight connections to finish.
+func streamServer(ctx context.Context, transport Transport, addr string, m *metrics, tlsConfig *tls.Config, shutdownTimeout time.Duration) error {
+	ln, err := transport.Listen(addr)
 	if err != nil {
 		return err
 	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			return err
+			select {
+			case <-ctx.Done():
+				waitWithTimeout(&wg, shutdownTimeout)
+				return nil
+			default:
+				return err
+			}
 		}
-		go handleConnection(conn)
+		m.recordConnection()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleConnection(conn, m)
+		}()
 	}
 }
 
-// handleConnection handles a single connection for the TCP server. The server reads the client's message and prints it
-// if the -debug flag was provided. Otherwise it waits for the connection to be closed, to reach EOF or '\n' before closing
-// the connection.
-func handleConnection(conn net.Conn) {
+// handleConnection handles a single connection for a connection-oriented server. The server reads the client's
+// message and prints it if the -debug flag was provided. Otherwise it waits for the connection to be closed, to
+// reach EOF or '\n' before closing the connection.
+func handleConnection(conn net.Conn, m *metrics) {
 	defer conn.Close()
 
 	msg, err := bufio.NewReader(conn).ReadString('\n')
 	if err != nil && err != io.EOF {
 		log.Printf("error reading from connection, err: %q", err)
 	}
+	m.recordMessage(len(msg))
 	if *debugFlag {
 		log.Printf("read from remote %s: %s", conn.RemoteAddr().String(), msg)
 	}
@@ -100,47 +175,193 @@ func handleConnection(conn net.Conn) {
 
 var (
 	serverFlag   = flag.Bool("server", false, "server")
-	protocolFlag = flag.String("protocol", "tcp", "protocol")
+	protocolFlag = flag.String("protocol", "tcp", "protocol: tcp, udp, unix or unixgram (for unix/unixgram, -host is used as the socket path)")
 	hostFlag     = flag.String("host", "127.0.0.1", "host")
 	portFlag     = flag.Int("port", 8080, "port")
 	rateFlag     = flag.Int("rate-per-second", 1000, "rate of connections per second")
 	debugFlag    = flag.Bool("debug", false, "debug")
+	workersFlag  = flag.Int("workers", 50, "number of client workers reading from the job queue")
+	queueFlag    = flag.Int("queue-size", 1000, "size of the buffered job queue fed by the rate ticker")
+	overflowFlag = flag.String("overflow", OverflowBlock, "behavior when the job queue is full: drop or block")
+
+	modeFlag            = flag.String("mode", ModeTransient, "connection mode: transient (dial per message) or persistent (reuse pooled connections)")
+	connectionsFlag     = flag.Int("connections", 10, "number of pooled connections when -mode=persistent")
+	messagesPerConnFlag = flag.Int("messages-per-conn", 0, "close and re-dial a pooled connection after this many writes, 0 means unlimited (only used with -mode=persistent)")
+
+	metricsAddrFlag = flag.String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled if empty)")
+
+	tlsFlag             = flag.Bool("tls", false, "enable TLS (client: dial via TLS, server: terminate TLS)")
+	tlsCertFlag         = flag.String("tls-cert", "", "path to a PEM certificate, required for -tls on the server, optional client cert on the client")
+	tlsKeyFlag          = flag.String("tls-key", "", "path to the PEM private key matching -tls-cert")
+	tlsCAFlag           = flag.String("tls-ca", "", "path to a PEM CA bundle; server: requires and verifies client certs (mTLS), client: verifies the server cert")
+	tlsServerNameFlag   = flag.String("tls-server-name", "", "expected server name for certificate verification (client only, defaults to -host)")
+	tlsInsecureFlag     = flag.Bool("tls-insecure", false, "skip server certificate verification (client only)")
+	tlsMinVersionFlag   = flag.String("tls-min-version", "1.2", "minimum TLS version to negotiate: 1.2 or 1.3")
+	tlsCipherSuitesFlag = flag.String("tls-cipher-suites", "", "comma-separated cipher suite names to allow (default: Go's built-in preference list)")
+
+	proxyFlag       = flag.Bool("proxy", false, "run as a TCP reverse-proxy/tee instead of a client or server")
+	upstreamFlag    = flag.String("upstream", "", "upstream host:port to forward connections to, required for -proxy")
+	teeFlag         = flag.String("tee", "", "additional host:port to best-effort mirror client->upstream traffic to")
+	proxyBufferFlag = flag.Int("proxy-buffer", 32*1024, "buffer size in bytes used to copy each direction of a proxied connection")
+
+	payloadFlag           = flag.String("payload", "", "message payload: fixed:N, rand:N, file:/path or template:/path (default: the literal \"msg\")")
+	payloadTerminatorFlag = flag.String("payload-terminator", "", "string appended after every payload, e.g. \\n so a ReadString('\\n') server can find the message boundary")
+	payloadSizeDistFlag   = flag.String("payload-size-dist", "const", "packet size distribution for -protocol=udp with -payload=fixed:N or rand:N: const, uniform:min-max or exp:mean")
+
+	durationFlag        = flag.Duration("duration", 0, "stop accepting new work after this long and shut down gracefully, 0 means run until SIGINT/SIGTERM (client: also until -count is reached)")
+	countFlag           = flag.Int("count", 0, "client only: stop after submitting this many messages and shut down gracefully, 0 means unlimited")
+	shutdownTimeoutFlag = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight work to drain during a graceful shutdown before giving up")
 )
 
 func main() {
 	// Parse command line flags.
 	flag.Parse()
 
-	var protocol string
-	switch *protocolFlag {
-	case TCP:
-		protocol = TCP
-	case UDP:
-		protocol = UDP
-	default:
-		log.Fatal("Invalid protocol")
+	transport, err := lookupTransport(*protocolFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr := transport.Addr(*hostFlag, *portFlag)
+
+	var dist sizeDist
+	if transport.Name() == UDP {
+		dist, err = parseSizeDist(*payloadSizeDistFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	payload, err := newPayloadSource(*payloadFlag, dist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	terminator := unescapeTerminator(*payloadTerminatorFlag)
+
+	minTLSVersion, err := parseTLSMinVersion(*tlsMinVersionFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cipherSuites, err := parseCipherSuites(*tlsCipherSuitesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m := newMetrics()
+	if *metricsAddrFlag != "" {
+		go startMetricsServer(*metricsAddrFlag, m)
+	}
+
+	// ctx is cancelled on SIGINT/SIGTERM or once -duration elapses (reaching -count stops the client's rate loop
+	// without cancelling ctx, see runRateLoop). Every
+	// worker, dialer and server accept loop honors it, so a run has a single, reproducible shutdown path instead of
+	// a blunt os.Exit on signal.
+	ctx, cancel := newRunContext(*durationFlag)
+	defer cancel()
+
+	go tickRatesForever(ctx, m)
+
+	if *serverFlag && *proxyFlag {
+		log.Fatal("-server and -proxy are mutually exclusive")
 	}
 
 	// Code for the server. See server() for more details.
 	if *serverFlag {
-		if err := server(protocol, *hostFlag, *portFlag); err != nil {
+		var serverTLS *tls.Config
+		if *tlsFlag {
+			serverTLS, err = serverTLSConfig(*tlsCertFlag, *tlsKeyFlag, *tlsCAFlag, minTLSVersion, cipherSuites)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := server(ctx, transport, addr, m, serverTLS, *shutdownTimeoutFlag); err != nil {
 			log.Fatalf("could not create server, err: %q", err)
 		}
+		fmt.Print(m.summary())
 		return
 	}
 
-	// Code for the client. The client calculates the sleep time between subsequent attempts based on the rate.
+	// Code for the proxy role. See runProxy() for more details.
+	if *proxyFlag {
+		if *upstreamFlag == "" {
+			log.Fatal("-upstream is required when -proxy is set")
+		}
+		hostPort := fmt.Sprintf("%s:%d", *hostFlag, *portFlag)
+		if err := runProxy(ctx, hostPort, *upstreamFlag, *teeFlag, *proxyBufferFlag, m, *shutdownTimeoutFlag); err != nil {
+			log.Fatalf("could not run proxy, err: %q", err)
+		}
+		fmt.Print(m.summary())
+		return
+	}
+
+	if *overflowFlag != OverflowDrop && *overflowFlag != OverflowBlock {
+		log.Fatalf("invalid -overflow value %q, must be %q or %q", *overflowFlag, OverflowDrop, OverflowBlock)
+	}
+
+	// Code for the client. The rate ticker calculates the sleep time between subsequent attempts based on the rate.
 	// For example, if the rate is 1000, sleep for 1,000,000,000 / 1,000 = 1,000,000 nanoseconds between messages
-	// -> send 100 messages per second.
+	// -> send 1000 messages per second. Jobs are fed into a bounded worker pool instead of spawning an unbounded
+	// number of goroutines, so the achievable rate stays honest under load.
 	sleepInterval := NANOSECONDS_PER_SECOND / *rateFlag
 	sleepTime := time.Nanosecond * time.Duration(sleepInterval)
+
+	dialer := &net.Dialer{}
+
+	var dial dialFunc
+	if *tlsFlag {
+		serverName := *tlsServerNameFlag
+		if serverName == "" {
+			serverName = *hostFlag
+		}
+		clientTLS, err := clientTLSConfig(*tlsCertFlag, *tlsKeyFlag, *tlsCAFlag, serverName, *tlsInsecureFlag, minTLSVersion, cipherSuites)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dial = tlsDialer(transport, dialer, clientTLS, addr)
+	} else {
+		dial = plainDialer(transport, dialer, addr)
+	}
+
+	var send sendFunc
+	switch *modeFlag {
+	case ModeTransient:
+		send = sendTransient(dial)
+	case ModePersistent:
+		if transport.IsPacket() {
+			log.Fatalf("-mode=%s is only supported with connection-oriented protocols", ModePersistent)
+		}
+		cp, err := newConnPool(ctx, dial, *connectionsFlag, *messagesPerConnFlag)
+		if err != nil {
+			log.Fatalf("could not create persistent connection pool, err: %q", err)
+		}
+		send = sendPersistent(cp)
+	default:
+		log.Fatalf("invalid -mode value %q, must be %q or %q", *modeFlag, ModeTransient, ModePersistent)
+	}
+	send = instrumented(send, m)
+
+	pool := newWorkerPool(*queueFlag, *overflowFlag, payload, terminator, m)
+	pool.start(ctx, *workersFlag, send)
+	runRateLoop(ctx, pool, sleepTime, *countFlag)
+	pool.wait(*shutdownTimeoutFlag)
+	fmt.Print(m.summary())
+}
+
+// unescapeTerminator expands the common backslash escapes (\n, \r, \t) in a flag value typed on a command line,
+// since shells don't interpret them inside a plain string argument.
+func unescapeTerminator(s string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t")
+	return replacer.Replace(s)
+}
+
+// tickRatesForever advances m's EWMA rates once per ewmaInterval until ctx is cancelled.
+func tickRatesForever(ctx context.Context, m *metrics) {
+	ticker := time.NewTicker(ewmaInterval)
+	defer ticker.Stop()
 	for {
-		time.Sleep(sleepTime)
-		// Run each client in its own go routine. See client() for the rest of the client logic.
-		go func() {
-			if err := client(protocol, *hostFlag, *portFlag, "msg"); err != nil {
-				log.Printf("got error on connection attempt, err: %q", err)
-			}
-		}()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.tickRates()
+		}
 	}
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	histMinNanos            = int64(time.Microsecond)
+	histMaxNanos            = int64(10 * time.Second)
+	histSubBucketsPerDecade = 10
+)
+
+// histogram is a log-linear latency histogram in the spirit of HDR histograms: bucket boundaries are spaced
+// geometrically (histSubBucketsPerDecade per decade) from histMinNanos to histMaxNanos, giving roughly constant
+// relative precision across the whole range instead of the poor tail resolution of linear buckets.
+type histogram struct {
+	bounds   []int64
+	counts   []uint64
+	total    uint64
+	sumNanos uint64
+	max      int64
+}
+
+// newHistogram builds a histogram with geometrically spaced buckets covering microseconds to seconds.
+func newHistogram() *histogram {
+	ratio := math.Pow(10, 1.0/histSubBucketsPerDecade)
+	var bounds []int64
+	for b := float64(histMinNanos); int64(b) < histMaxNanos; b *= ratio {
+		bounds = append(bounds, int64(b))
+	}
+	bounds = append(bounds, histMaxNanos)
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+// record adds a single latency sample to the histogram.
+func (h *histogram) record(d time.Duration) {
+	nanos := d.Nanoseconds()
+	if nanos < histMinNanos {
+		nanos = histMinNanos
+	}
+	idx := sort.Search(len(h.bounds), func(i int) bool { return h.bounds[i] >= nanos })
+	if idx >= len(h.counts) {
+		idx = len(h.counts) - 1
+	}
+	atomic.AddUint64(&h.counts[idx], 1)
+	atomic.AddUint64(&h.total, 1)
+	atomic.AddUint64(&h.sumNanos, uint64(nanos))
+	for {
+		old := atomic.LoadInt64(&h.max)
+		if nanos <= old {
+			break
+		}
+		if atomic.CompareAndSwapInt64(&h.max, old, nanos) {
+			break
+		}
+	}
+}
+
+// percentile returns the smallest bucket boundary containing at least the p-th percentile of recorded samples.
+func (h *histogram) percentile(p float64) time.Duration {
+	total := atomic.LoadUint64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(p / 100 * float64(total)))
+	if target < 1 {
+		target = 1
+	}
+	var cum uint64
+	for i, bound := range h.bounds {
+		cum += atomic.LoadUint64(&h.counts[i])
+		if cum >= target {
+			return time.Duration(bound)
+		}
+	}
+	return time.Duration(h.bounds[len(h.bounds)-1])
+}
+
+// sumSeconds returns the total of all recorded latency samples, in seconds, as required by the Prometheus
+// histogram metric type's "_sum" series.
+func (h *histogram) sumSeconds() float64 {
+	return float64(atomic.LoadUint64(&h.sumNanos)) / float64(time.Second)
+}
+
+// maxDuration returns the largest latency sample recorded so far.
+func (h *histogram) maxDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max))
+}
+
+// metrics holds everything the client and server instrument: request/error counts and latency on the client side,
+// and byte/message/connection counts plus EWMA rates on the server side.
+type metrics struct {
+	requestsTotal uint64
+	latency       *histogram
+
+	errorsMu sync.Mutex
+	errors   map[string]uint64
+
+	bytesTotal       uint64
+	messagesTotal    uint64
+	connectionsTotal uint64
+
+	droppedTotal uint64
+
+	byteRate       *ewma
+	messageRate    *ewma
+	connectionRate *ewma
+}
+
+// newMetrics returns a ready-to-use metrics instance.
+func newMetrics() *metrics {
+	return &metrics{
+		latency:        newHistogram(),
+		errors:         make(map[string]uint64),
+		byteRate:       newEWMA(),
+		messageRate:    newEWMA(),
+		connectionRate: newEWMA(),
+	}
+}
+
+// recordRequest records the outcome of one client request: its latency, and, if it failed, the kind of error.
+func (m *metrics) recordRequest(d time.Duration, err error) {
+	atomic.AddUint64(&m.requestsTotal, 1)
+	m.latency.record(d)
+	if err != nil {
+		kind := errorKind(err)
+		m.errorsMu.Lock()
+		m.errors[kind]++
+		m.errorsMu.Unlock()
+	}
+}
+
+// recordConnection accounts for a newly accepted server-side connection.
+func (m *metrics) recordConnection() {
+	atomic.AddUint64(&m.connectionsTotal, 1)
+	m.connectionRate.update(1)
+}
+
+// recordMessage accounts for a single message of n bytes received by the server.
+func (m *metrics) recordMessage(n int) {
+	atomic.AddUint64(&m.messagesTotal, 1)
+	atomic.AddUint64(&m.bytesTotal, uint64(n))
+	m.messageRate.update(1)
+	m.byteRate.update(int64(n))
+}
+
+// recordBytes accounts for n bytes of proxied traffic without incrementing the message counter: a raw TCP proxy
+// has no message framing of its own, unlike the packetServer/handleConnection reads recordMessage is meant for.
+func (m *metrics) recordBytes(n int) {
+	atomic.AddUint64(&m.bytesTotal, uint64(n))
+	m.byteRate.update(int64(n))
+}
+
+// recordDrop accounts for a client job dropped by the worker pool because its queue was full (-overflow=drop).
+func (m *metrics) recordDrop() {
+	atomic.AddUint64(&m.droppedTotal, 1)
+}
+
+// tickRates advances the EWMA rates once; it is meant to be called once per ewmaInterval, the period the rates are computed against.
+func (m *metrics) tickRates() {
+	m.byteRate.tick()
+	m.messageRate.tick()
+	m.connectionRate.tick()
+}
+
+// errorKind classifies err into a coarse, stable label suitable for a metrics label or a summary breakdown.
+func errorKind(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno.Error()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "other"
+}
+
+// writePrometheus writes m in Prometheus text exposition format.
+func (m *metrics) writePrometheus(w http.ResponseWriter) {
+	fmt.Fprintf(w, "# HELP loadgen_requests_total Total number of client requests attempted.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_requests_total counter\n")
+	fmt.Fprintf(w, "loadgen_requests_total %d\n", atomic.LoadUint64(&m.requestsTotal))
+
+	fmt.Fprintf(w, "# HELP loadgen_errors_total Total number of client request errors, by kind.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_errors_total counter\n")
+	m.errorsMu.Lock()
+	for kind, count := range m.errors {
+		fmt.Fprintf(w, "loadgen_errors_total{kind=%q} %d\n", kind, count)
+	}
+	m.errorsMu.Unlock()
+
+	fmt.Fprintf(w, "# HELP loadgen_latency_seconds Client request latency in seconds.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_latency_seconds histogram\n")
+	var cum uint64
+	for i, bound := range m.latency.bounds {
+		cum += atomic.LoadUint64(&m.latency.counts[i])
+		fmt.Fprintf(w, "loadgen_latency_seconds_bucket{le=%q} %d\n", formatSeconds(bound), cum)
+	}
+	fmt.Fprintf(w, "loadgen_latency_seconds_bucket{le=\"+Inf\"} %d\n", cum)
+	fmt.Fprintf(w, "loadgen_latency_seconds_sum %g\n", m.latency.sumSeconds())
+	fmt.Fprintf(w, "loadgen_latency_seconds_count %d\n", cum)
+
+	fmt.Fprintf(w, "# HELP loadgen_server_bytes_total Total bytes received by the server.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_server_bytes_total counter\n")
+	fmt.Fprintf(w, "loadgen_server_bytes_total %d\n", atomic.LoadUint64(&m.bytesTotal))
+
+	fmt.Fprintf(w, "# HELP loadgen_server_messages_total Total messages received by the server.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_server_messages_total counter\n")
+	fmt.Fprintf(w, "loadgen_server_messages_total %d\n", atomic.LoadUint64(&m.messagesTotal))
+
+	fmt.Fprintf(w, "# HELP loadgen_server_connections_total Total connections accepted by the server.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_server_connections_total counter\n")
+	fmt.Fprintf(w, "loadgen_server_connections_total %d\n", atomic.LoadUint64(&m.connectionsTotal))
+
+	fmt.Fprintf(w, "# HELP loadgen_server_bytes_per_second EWMA of bytes received per second.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_server_bytes_per_second gauge\n")
+	fmt.Fprintf(w, "loadgen_server_bytes_per_second %g\n", m.byteRate.value())
+
+	fmt.Fprintf(w, "# HELP loadgen_server_messages_per_second EWMA of messages received per second.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_server_messages_per_second gauge\n")
+	fmt.Fprintf(w, "loadgen_server_messages_per_second %g\n", m.messageRate.value())
+
+	fmt.Fprintf(w, "# HELP loadgen_server_connections_per_second EWMA of connections accepted per second.\n")
+	fmt.Fprintf(w, "# TYPE loadgen_server_connections_per_second gauge\n")
+	fmt.Fprintf(w, "loadgen_server_connections_per_second %g\n", m.connectionRate.value())
+
+	fmt.Fprintf(w, "# HELP loadgen_dropped_total Total client jobs dropped because the queue was full (-overflow=drop).\n")
+	fmt.Fprintf(w, "# TYPE loadgen_dropped_total counter\n")
+	fmt.Fprintf(w, "loadgen_dropped_total %d\n", atomic.LoadUint64(&m.droppedTotal))
+}
+
+// formatSeconds renders a nanosecond bucket boundary as a Prometheus-style float seconds string.
+func formatSeconds(nanos int64) string {
+	return fmt.Sprintf("%g", float64(nanos)/float64(time.Second))
+}
+
+// summary renders the plain-text summary printed at the end of a run, covering both the client-side
+// requests/latency/errors fields and the server-side bytes/messages/connections counters and EWMA rates. Whichever
+// half doesn't apply to the role that was run is simply all zeroes.
+func (m *metrics) summary() string {
+	return fmt.Sprintf(
+		"requests=%d errors=%d dropped=%d\n"+
+			"latency: p50=%s p90=%s p99=%s p999=%s max=%s\n"+
+			"errors by kind: %v\n"+
+			"server: bytes=%d messages=%d connections=%d\n"+
+			"server rates (ewma): bytes/s=%.2f messages/s=%.2f connections/s=%.2f\n",
+		atomic.LoadUint64(&m.requestsTotal),
+		m.errorCount(),
+		atomic.LoadUint64(&m.droppedTotal),
+		m.latency.percentile(50),
+		m.latency.percentile(90),
+		m.latency.percentile(99),
+		m.latency.percentile(99.9),
+		m.latency.maxDuration(),
+		m.errorsSnapshot(),
+		atomic.LoadUint64(&m.bytesTotal),
+		atomic.LoadUint64(&m.messagesTotal),
+		atomic.LoadUint64(&m.connectionsTotal),
+		m.byteRate.value(),
+		m.messageRate.value(),
+		m.connectionRate.value(),
+	)
+}
+
+// errorCount returns the total number of recorded errors across all kinds.
+func (m *metrics) errorCount() uint64 {
+	m.errorsMu.Lock()
+	defer m.errorsMu.Unlock()
+	var total uint64
+	for _, c := range m.errors {
+		total += c
+	}
+	return total
+}
+
+// errorsSnapshot returns a copy of the error-kind breakdown, safe to print or range over after the lock is released.
+func (m *metrics) errorsSnapshot() map[string]uint64 {
+	m.errorsMu.Lock()
+	defer m.errorsMu.Unlock()
+	snapshot := make(map[string]uint64, len(m.errors))
+	for kind, count := range m.errors {
+		snapshot[kind] = count
+	}
+	return snapshot
+}
+
+// startMetricsServer serves Prometheus-format metrics on addr at /metrics until the process exits.
+func startMetricsServer(addr string, m *metrics) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m.writePrometheus(w)
+	})
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Printf("metrics server stopped, err: %q\n", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PayloadSource produces the bytes written for a single message. seq is a monotonically increasing sequence
+// number assigned when the job was enqueued; workerID identifies the worker sending it. Both are only meaningful
+// to template payloads, but are passed to every source for a uniform interface.
+type PayloadSource interface {
+	Generate(seq uint64, workerID int) (string, error)
+}
+
+// staticPayload always returns the same, pre-computed payload: used for -payload=fixed:N, rand:N (without a size
+// distribution), and file:/path, none of which vary from one send to the next.
+type staticPayload struct {
+	data string
+}
+
+func (p staticPayload) Generate(seq uint64, workerID int) (string, error) {
+	return p.data, nil
+}
+
+// dynamicSizePayload regenerates a payload of a freshly sampled size on every call, used for -protocol=udp
+// combined with -payload-size-dist. fillByte is repeated to build the payload if non-zero; otherwise fresh random
+// bytes are generated per call.
+type dynamicSizePayload struct {
+	fillByte byte
+	dist     sizeDist
+}
+
+func (p dynamicSizePayload) Generate(seq uint64, workerID int) (string, error) {
+	n := p.dist.Sample()
+	if p.fillByte != 0 {
+		return strings.Repeat(string(p.fillByte), n), nil
+	}
+	buf := make([]byte, n)
+	if _, err := crand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate random payload: %w", err)
+	}
+	return string(buf), nil
+}
+
+// templateVars are the fields exposed to a -payload=template:/path template.
+type templateVars struct {
+	Seq           uint64
+	TimestampNano int64
+	WorkerID      int
+}
+
+// templatePayload evaluates a Go text/template once per send with the current sequence number, timestamp and
+// worker ID.
+type templatePayload struct {
+	tmpl *template.Template
+}
+
+func (p *templatePayload) Generate(seq uint64, workerID int) (string, error) {
+	var buf bytes.Buffer
+	vars := templateVars{Seq: seq, TimestampNano: time.Now().UnixNano(), WorkerID: workerID}
+	if err := p.tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("could not execute payload template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// newPayloadSource parses the -payload flag value into a PayloadSource. An empty spec preserves the tool's
+// original hardcoded "msg" payload. dist, if non-nil, overrides the size of fixed/rand payloads per send (see
+// -payload-size-dist); it has no effect on file or template payloads.
+func newPayloadSource(spec string, dist sizeDist) (PayloadSource, error) {
+	if spec == "" {
+		return staticPayload{data: "msg"}, nil
+	}
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "fixed":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid -payload fixed size %q", arg)
+		}
+		if dist != nil {
+			return dynamicSizePayload{fillByte: 'x', dist: dist}, nil
+		}
+		return staticPayload{data: strings.Repeat("x", n)}, nil
+	case "rand":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid -payload rand size %q", arg)
+		}
+		if dist != nil {
+			return dynamicSizePayload{dist: dist}, nil
+		}
+		buf := make([]byte, n)
+		if _, err := crand.Read(buf); err != nil {
+			return nil, fmt.Errorf("could not generate random payload: %w", err)
+		}
+		return staticPayload{data: string(buf)}, nil
+	case "file":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("could not read -payload file %q: %w", arg, err)
+		}
+		return staticPayload{data: string(data)}, nil
+	case "template":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("could not read -payload template %q: %w", arg, err)
+		}
+		tmpl, err := template.New(filepath.Base(arg)).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse -payload template %q: %w", arg, err)
+		}
+		return &templatePayload{tmpl: tmpl}, nil
+	default:
+		return nil, fmt.Errorf("unsupported -payload kind %q, must be fixed, rand, file or template", kind)
+	}
+}
+
+// sizeDist samples a packet size in bytes, letting -payload-size-dist sweep UDP packet sizes instead of sending a
+// constant size on every message.
+type sizeDist interface {
+	Sample() int
+}
+
+// uniformDist samples uniformly from [min, max], inclusive.
+type uniformDist struct {
+	min, max int
+}
+
+func (d *uniformDist) Sample() int {
+	return d.min + rand.Intn(d.max-d.min+1)
+}
+
+// expDist samples from an exponential distribution with the given mean.
+type expDist struct {
+	mean float64
+}
+
+func (d *expDist) Sample() int {
+	n := int(rand.ExpFloat64() * d.mean)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// parseSizeDist parses the -payload-size-dist flag value. An empty spec, or "const", disables size sweeping
+// (nil, nil): the payload source's own fixed size is used on every send.
+func parseSizeDist(spec string) (sizeDist, error) {
+	if spec == "" || spec == "const" {
+		return nil, nil
+	}
+	if rest, ok := strings.CutPrefix(spec, "uniform:"); ok {
+		min, max, ok := strings.Cut(rest, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid -payload-size-dist %q, expected uniform:min-max", spec)
+		}
+		minN, err := strconv.Atoi(min)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -payload-size-dist %q: %w", spec, err)
+		}
+		maxN, err := strconv.Atoi(max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -payload-size-dist %q: %w", spec, err)
+		}
+		if maxN < minN {
+			return nil, fmt.Errorf("invalid -payload-size-dist %q: max < min", spec)
+		}
+		return &uniformDist{min: minN, max: maxN}, nil
+	}
+	if rest, ok := strings.CutPrefix(spec, "exp:"); ok {
+		mean, err := strconv.ParseFloat(rest, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -payload-size-dist %q: %w", spec, err)
+		}
+		return &expDist{mean: mean}, nil
+	}
+	return nil, fmt.Errorf("unsupported -payload-size-dist %q, must be const, uniform:min-max or exp:mean", spec)
+}
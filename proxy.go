@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// teeWriteTimeout bounds how long a tee write is allowed to block before it is abandoned, keeping the tee sink
+// best-effort: a slow or stuck mirror must never hold up the primary proxy path.
+const teeWriteTimeout = 50 * time.Millisecond
+
+// teeQueueDepth bounds how many not-yet-mirrored chunks bestEffortWriter will hold for a slow tee sink before it
+// starts dropping them, so a stuck mirror sheds load instead of applying backpressure to the primary copy.
+const teeQueueDepth = 256
+
+// bestEffortWriter mirrors writes to conn on a background goroutine, decoupling the mirror entirely from the
+// caller: Write copies the chunk onto a buffered queue and returns immediately, never blocking on the tee
+// connection. If the queue is full (the sink can't keep up), the chunk is dropped instead of applying
+// backpressure to the primary proxy path.
+type bestEffortWriter struct {
+	queue chan []byte
+	done  chan struct{}
+}
+
+// newBestEffortWriter starts mirroring writes to conn in the background, closing conn once the writer is closed.
+func newBestEffortWriter(conn net.Conn) *bestEffortWriter {
+	w := &bestEffortWriter{
+		queue: make(chan []byte, teeQueueDepth),
+		done:  make(chan struct{}),
+	}
+	go w.run(conn)
+	return w
+}
+
+func (w *bestEffortWriter) run(conn net.Conn) {
+	defer close(w.done)
+	defer conn.Close()
+	for chunk := range w.queue {
+		conn.SetWriteDeadline(time.Now().Add(teeWriteTimeout))
+		conn.Write(chunk)
+	}
+}
+
+func (w *bestEffortWriter) Write(p []byte) (int, error) {
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	select {
+	case w.queue <- chunk:
+	default:
+	}
+	return len(p), nil
+}
+
+// Close stops mirroring and waits for the background writer goroutine to drain its queue and close the
+// underlying connection.
+func (w *bestEffortWriter) Close() {
+	close(w.queue)
+	<-w.done
+}
+
+// runProxy listens on hostPort and, for every accepted connection, forwards traffic to upstream, optionally
+// mirroring client-to-upstream traffic to tee. It runs until ctx is cancelled, at which point it closes the
+// listener and waits, bounded by shutdownTimeout, for in-flight connections to finish before returning.
+func runProxy(ctx context.Context, hostPort, upstream, tee string, bufferSize int, m *metrics, shutdownTimeout time.Duration) error {
+	ln, err := net.Listen(TCP, hostPort)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				waitWithTimeout(&wg, shutdownTimeout)
+				return nil
+			default:
+				return err
+			}
+		}
+		m.recordConnection()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proxyConn(conn, upstream, tee, bufferSize, m)
+		}()
+	}
+}
+
+// proxyConn forwards a single accepted connection to upstream, running both directions concurrently and closing
+// both ends as soon as either direction hits EOF or an error. Client-to-upstream bytes are best-effort mirrored to
+// tee, if configured.
+func proxyConn(conn net.Conn, upstream, tee string, bufferSize int, m *metrics) {
+	defer conn.Close()
+
+	upConn, err := net.Dial(TCP, upstream)
+	if err != nil {
+		log.Printf("could not dial upstream %q, err: %q", upstream, err)
+		return
+	}
+	defer upConn.Close()
+
+	var teeWriter io.Writer
+	if tee != "" {
+		teeConn, err := net.Dial(TCP, tee)
+		if err != nil {
+			log.Printf("could not dial tee sink %q, err: %q", tee, err)
+		} else {
+			w := newBestEffortWriter(teeConn)
+			defer w.Close()
+			teeWriter = w
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go copyAndClose(upConn, conn, teeWriter, bufferSize, m, &wg)
+	go copyAndClose(conn, upConn, nil, bufferSize, m, &wg)
+	wg.Wait()
+}
+
+// copyAndClose copies from src to dst (additionally mirroring to tee, if non-nil) until EOF or an error, recording
+// each chunk's byte count into m as it is written, then closes both src and dst so the other direction's copy
+// unblocks and exits too.
+func copyAndClose(dst, src net.Conn, tee io.Writer, bufferSize int, m *metrics, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer dst.Close()
+	defer src.Close()
+
+	var w io.Writer = dst
+	if tee != nil {
+		w = io.MultiWriter(dst, tee)
+	}
+	io.CopyBuffer(countingWriter{w: w, m: m}, src, make([]byte, bufferSize))
+}
+
+// countingWriter wraps an io.Writer, recording each write's byte count into m as it happens rather than only the
+// connection-lifetime total at close, so the proxy's byte rate reflects a steady stream instead of one end-of-
+// connection burst.
+type countingWriter struct {
+	w io.Writer
+	m *metrics
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if n > 0 {
+		c.m.recordBytes(n)
+	}
+	return n, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// newRunContext returns a context that is cancelled on SIGINT/SIGTERM or, if duration is non-zero, once duration
+// has elapsed, whichever comes first. Every worker, dialer and server accept loop is expected to honor it so a run
+// has a clean, reproducible stopping point instead of running forever.
+func newRunContext(duration time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if duration > 0 {
+		go func() {
+			select {
+			case <-time.After(duration):
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, cancel
+}
+
+// waitWithTimeout waits for wg to finish, but gives up after timeout so a stuck or slow in-flight request can't
+// hang shutdown forever.
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("shutdown timeout of %s reached, abandoning remaining in-flight work", timeout)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsVersions maps the -tls-min-version flag's accepted values to their crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSMinVersion resolves the -tls-min-version flag value, defaulting to TLS 1.2 when empty.
+func parseTLSMinVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported -tls-min-version %q, must be one of 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites resolves a comma-separated list of cipher suite names (as returned by tls.CipherSuiteName) into
+// their IDs for use in a tls.Config. An empty string returns a nil slice, letting crypto/tls pick its own defaults.
+func parseCipherSuites(names string) ([]uint16, error) {
+	if names == "" {
+		return nil, nil
+	}
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadCAPool reads a PEM-encoded CA bundle from path into a fresh cert pool.
+func loadCAPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle %q: %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("could not parse any certificates from CA bundle %q", path)
+	}
+	return pool, nil
+}
+
+// clientTLSConfig builds the tls.Config used to dial the server when -tls is set.
+func clientTLSConfig(certFile, keyFile, caFile, serverName string, insecure bool, minVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client cert/key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := loadCAPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// serverTLSConfig builds the tls.Config used by the TCP server when -tls is set. If caFile is non-empty, the server
+// requires and verifies client certificates against it, turning the listener into an mTLS listener.
+func serverTLSConfig(certFile, keyFile, caFile string, minVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("-tls-cert and -tls-key are required when -tls is set on the server")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load server cert/key: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+	if caFile != "" {
+		pool, err := loadCAPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
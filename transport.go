@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Transport abstracts the network underneath the client and server so that adding a new one (QUIC, SCTP, ...) is a
+// matter of implementing this interface and registering it, rather than threading another protocol switch through
+// client(), server() and friends.
+type Transport interface {
+	// Name is the network name passed to the standard library, e.g. "tcp" or "unixgram".
+	Name() string
+	// IsPacket reports whether this transport is connectionless (served via ListenPacket/ReadFrom) rather than
+	// connection-oriented (served via Listen/Accept).
+	IsPacket() bool
+	// Addr builds the dial/listen address for this transport from the generic -host/-port flags. IP
+	// transports (tcp, udp) combine them into "host:port"; Unix-domain transports use -host verbatim as a
+	// socket path and ignore the port.
+	Addr(host string, port int) string
+	// Dial opens a single connection to addr.
+	Dial(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error)
+	// Listen is only valid when !IsPacket().
+	Listen(addr string) (net.Listener, error)
+	// ListenPacket is only valid when IsPacket().
+	ListenPacket(addr string) (net.PacketConn, error)
+}
+
+// streamTransport implements Transport for connection-oriented networks (tcp, unix) via net.Dial/net.Listen.
+type streamTransport struct {
+	name string
+}
+
+func (t streamTransport) Name() string   { return t.name }
+func (t streamTransport) IsPacket() bool { return false }
+
+func (t streamTransport) Addr(host string, port int) string {
+	if t.name == Unix {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+func (t streamTransport) Dial(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	return dialer.DialContext(ctx, t.name, addr)
+}
+
+func (t streamTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen(t.name, addr)
+}
+
+func (t streamTransport) ListenPacket(addr string) (net.PacketConn, error) {
+	return nil, fmt.Errorf("%s: transport is connection-oriented, ListenPacket is not supported", t.name)
+}
+
+// packetTransport implements Transport for connectionless networks (udp, unixgram) via net.Dial/net.ListenPacket.
+type packetTransport struct {
+	name string
+}
+
+func (t packetTransport) Name() string   { return t.name }
+func (t packetTransport) IsPacket() bool { return true }
+
+func (t packetTransport) Addr(host string, port int) string {
+	if t.name == Unixgram {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+func (t packetTransport) Dial(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	return dialer.DialContext(ctx, t.name, addr)
+}
+
+func (t packetTransport) Listen(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("%s: transport is connectionless, Listen is not supported", t.name)
+}
+
+func (t packetTransport) ListenPacket(addr string) (net.PacketConn, error) {
+	return net.ListenPacket(t.name, addr)
+}
+
+// transports is the transport registry, keyed by the -protocol flag value. Adding a new transport only requires a
+// new entry here plus a Transport implementation.
+var transports = map[string]Transport{
+	TCP:      streamTransport{name: TCP},
+	UDP:      packetTransport{name: UDP},
+	Unix:     streamTransport{name: Unix},
+	Unixgram: packetTransport{name: Unixgram},
+}
+
+// lookupTransport resolves the -protocol flag value to a registered Transport.
+func lookupTransport(name string) (Transport, error) {
+	t, ok := transports[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol: %q", name)
+	}
+	return t, nil
+}
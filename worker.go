@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OverflowDrop  = "drop"
+	OverflowBlock = "block"
+)
+
+// job describes a single unit of client work to be picked up by a worker. seq is assigned when the job is
+// submitted so payload sources (e.g. -payload=template:...) can observe a stable per-message sequence number
+// regardless of which worker ends up sending it.
+type job struct {
+	seq uint64
+}
+
+// sendFunc sends a single message, using whatever transport/connection strategy the caller configured (a fresh
+// dial per message, a pooled persistent connection, TLS, etc.). Workers are agnostic to which one they were given.
+type sendFunc func(ctx context.Context, message string) error
+
+// dialFunc opens a single connection to the (fixed) target the caller configured it for, plain or TLS. It lets
+// client(), connPool and friends stay agnostic of how the connection is actually established.
+type dialFunc func(ctx context.Context) (net.Conn, error)
+
+// workerPool runs a fixed set of workers that consume jobs from a buffered channel, render each job's payload, and
+// feed it to send. It keeps track of the number of jobs dropped due to a full queue when the overflow policy is
+// "drop".
+type workerPool struct {
+	jobs       chan job
+	overflow   string
+	seq        uint64
+	payload    PayloadSource
+	terminator string
+	metrics    *metrics
+	wg         sync.WaitGroup
+}
+
+// newWorkerPool allocates a workerPool with the given queue depth, overflow policy, payload source and message
+// terminator (appended after every rendered payload, e.g. so a TCP server reading with ReadString('\n') can find
+// the message boundary). Jobs dropped under -overflow=drop are recorded into m, so the drop count is visible
+// alongside every other client metric instead of being tracked nowhere.
+func newWorkerPool(queueSize int, overflow string, payload PayloadSource, terminator string, m *metrics) *workerPool {
+	return &workerPool{
+		jobs:       make(chan job, queueSize),
+		overflow:   overflow,
+		payload:    payload,
+		terminator: terminator,
+		metrics:    m,
+	}
+}
+
+// start launches n workers that range over the jobs channel until ctx is cancelled, rendering each job's payload
+// and handing the result to send. wait blocks, bounded by a shutdown timeout, until every launched worker has
+// returned, so in-flight sends get a chance to finish draining before the caller prints a final summary.
+func (p *workerPool) start(ctx context.Context, n int, send sendFunc) {
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(id int) {
+			defer p.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j, ok := <-p.jobs:
+					if !ok {
+						return
+					}
+					message, err := p.payload.Generate(j.seq, id)
+					if err != nil {
+						log.Printf("worker %d: could not render payload, err: %q", id, err)
+						continue
+					}
+					if err := send(ctx, message+p.terminator); err != nil {
+						log.Printf("worker %d: got error on connection attempt, err: %q", id, err)
+					}
+				}
+			}
+		}(i)
+	}
+}
+
+// submit enqueues a new job, honoring the configured overflow policy when the queue is full: "block" waits for
+// room (or for ctx to be cancelled, so a full queue can't hang a graceful shutdown once workers have stopped
+// consuming), "drop" records the drop in p.metrics and returns immediately.
+func (p *workerPool) submit(ctx context.Context) {
+	j := job{seq: atomic.AddUint64(&p.seq, 1)}
+	if p.overflow == OverflowDrop {
+		select {
+		case p.jobs <- j:
+		default:
+			p.metrics.recordDrop()
+		}
+		return
+	}
+	select {
+	case p.jobs <- j:
+	case <-ctx.Done():
+	}
+}
+
+// close stops accepting new jobs by closing the jobs channel.
+func (p *workerPool) close() {
+	close(p.jobs)
+}
+
+// wait blocks until every worker launched by start has returned, or until timeout elapses, whichever comes first.
+func (p *workerPool) wait(timeout time.Duration) {
+	waitWithTimeout(&p.wg, timeout)
+}
+
+// runRateLoop feeds one job per tick into the pool at the configured rate until ctx is cancelled, or, if count is
+// positive, until count jobs have been submitted. In the count case it stops feeding and closes the pool's jobs
+// channel rather than cancelling ctx outright, so the count-th job's send is allowed to finish in-flight instead
+// of being aborted mid-flight. Jobs dropped under -overflow=drop still count toward count.
+func runRateLoop(ctx context.Context, p *workerPool, sleepTime time.Duration, count int) {
+	ticker := time.NewTicker(sleepTime)
+	defer ticker.Stop()
+	submitted := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.submit(ctx)
+			submitted++
+			if count > 0 && submitted >= count {
+				p.close()
+				return
+			}
+		}
+	}
+}